@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Client fetches one window of a blog's posts. LegacyClient talks to the
+// legacy /api/read/json endpoint; V2Client talks to the official v2 API.
+// NewTumblr is a thin facade over whichever Client main() configures.
+type Client interface {
+	FetchPage(blogUrl string, start, num int, silent bool) (Tumblr, error)
+
+	// FetchRaw returns the same window's response body unparsed, for -raw.
+	// It goes through whichever backend FetchPage would use, so -raw shows
+	// what's actually configured instead of always the legacy endpoint.
+	FetchRaw(blogUrl string, start, num int, silent bool) ([]byte, error)
+}
+
+// LegacyClient is the original /api/read/json backend, including the
+// malformed-page fallback from fetchPostsIndividually.
+type LegacyClient struct{}
+
+func (LegacyClient) FetchPage(blogUrl string, start, num int, silent bool) (Tumblr, error) {
+	contents := fetchJson(blogUrl, start, num, silent)
+
+	var t Tumblr
+	if err := json.Unmarshal(contents, &t); err != nil {
+		if !silent {
+			fmt.Fprintf(humanOut, "Malformed response for %s (start=%d): %v -- falling back to per-post fetch\n", blogUrl, start, err)
+		}
+		return fetchPostsIndividually(blogUrl, start, num), nil
+	}
+	return t, nil
+}
+
+func (LegacyClient) FetchRaw(blogUrl string, start, num int, silent bool) ([]byte, error) {
+	return fetchJson(blogUrl, start, num, silent), nil
+}
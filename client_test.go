@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// tumblrAPIPage renders a single legacy /api/read/json response body,
+// wrapped the way the real endpoint wraps it (fetchJson strips this back
+// off via filterContent).
+func tumblrAPIPage(postId string) string {
+	return fmt.Sprintf(
+		`var tumblr_api_read = {"tumblelog":{"title":"Test Blog","name":"testblog"},"posts-total":3,"posts":[{"id":"%s","type":"photo","photo-url-1280":"https://example.com/%s.jpg"}]};`,
+		postId, postId,
+	)
+}
+
+// TestFetchPostsIndividually verifies the malformed-page fallback: when one
+// offset in the window comes back malformed, it's logged and skipped rather
+// than aborting the whole window, and the surrounding good posts are still
+// stitched together.
+func TestFetchPostsIndividually(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch q.Get("start") {
+		case "0":
+			fmt.Fprint(w, tumblrAPIPage("0"))
+		case "1":
+			// Simulate the known malformed-response Tumblr server bug.
+			fmt.Fprint(w, `var tumblr_api_read = not valid json at all;`)
+		case "2":
+			fmt.Fprint(w, tumblrAPIPage("2"))
+		default:
+			t.Fatalf("unexpected start=%s", q.Get("start"))
+		}
+	}))
+	defer ts.Close()
+
+	got := fetchPostsIndividually(ts.URL, 0, 3)
+
+	if len(got.Posts) != 2 {
+		t.Fatalf("got %d posts, want 2 (offset 1 should be skipped): %+v", len(got.Posts), got.Posts)
+	}
+	if got.Posts[0].Id != "0" || got.Posts[1].Id != "2" {
+		t.Errorf("got post ids %q, %q, want \"0\", \"2\"", got.Posts[0].Id, got.Posts[1].Id)
+	}
+	if got.Blog.Title != "Test Blog" {
+		t.Errorf("Blog.Title = %q, want the metadata from the first successful response", got.Blog.Title)
+	}
+	if got.NumberOfPosts != 3 {
+		t.Errorf("NumberOfPosts = %d, want 3", got.NumberOfPosts)
+	}
+}
+
+// TestLegacyClientFetchPageFallsBackOnMalformedPage checks that LegacyClient
+// routes a malformed batched response through fetchPostsIndividually
+// instead of surfacing the unmarshal error, since a single bad post in a
+// batch is a known, recoverable server bug rather than a real failure.
+func TestLegacyClientFetchPageFallsBackOnMalformedPage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if num := q.Get("num"); num == "2" {
+			// The batched request itself is malformed.
+			fmt.Fprint(w, `var tumblr_api_read = not valid json at all;`)
+			return
+		}
+		// Per-post fallback requests (num=1).
+		fmt.Fprint(w, tumblrAPIPage(q.Get("start")))
+	}))
+	defer ts.Close()
+
+	got, err := LegacyClient{}.FetchPage(ts.URL, 0, 2, true)
+	if err != nil {
+		t.Fatalf("FetchPage returned an error instead of falling back: %v", err)
+	}
+	if len(got.Posts) != 2 {
+		t.Fatalf("got %d posts, want 2 from the per-post fallback: %+v", len(got.Posts), got.Posts)
+	}
+	if !strings.HasPrefix(got.Posts[0].Id, "0") {
+		t.Errorf("got first post id %q, want it to start from offset 0", got.Posts[0].Id)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// humanOut is where the free-form, human-readable progress messages go. In
+// -json mode it's switched to os.Stderr so stdout carries nothing but the
+// newline-delimited event stream.
+var humanOut io.Writer = os.Stdout
+
+// event is one newline-delimited JSON record emitted in -json mode. This
+// mirrors the streaming-status pattern used by tools like Docker's pull API,
+// letting the downloader be embedded as a subprocess without screen-scraping
+// its human output.
+type event struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Page       int       `json:"page,omitempty"`
+	PostId     string    `json:"post_id,omitempty"`
+	PostType   string    `json:"post_type,omitempty"`
+	Url        string    `json:"url,omitempty"`
+	Filename   string    `json:"filename,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Succeeded  int       `json:"succeeded,omitempty"`
+	Failed     int       `json:"failed,omitempty"`
+}
+
+// eventEmitter writes events as newline-delimited JSON. A nil *eventEmitter
+// is valid and simply drops events, so call sites don't need to guard every
+// emit() call behind a -json check.
+type eventEmitter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newEventEmitter(w io.Writer) *eventEmitter {
+	return &eventEmitter{enc: json.NewEncoder(w)}
+}
+
+func (e *eventEmitter) emit(ev event) {
+	if e == nil {
+		return
+	}
+	ev.Time = time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(ev)
+}
+
+// events is the process-wide emitter. It stays nil unless -json is passed,
+// in which case main() wires it up to stdout.
+var events *eventEmitter
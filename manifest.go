@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// manifestPath is the default location of the local dedupe database. It
+// records every post already downloaded so -resume can make "-all" crawls
+// restartable and safe to run on a cron without redownloading gigabytes.
+const manifestPath = ".tumblr-download.state"
+
+type manifestAsset struct {
+	Url  string `json:"url"`
+	Hash string `json:"hash"`
+}
+
+// manifest tracks, per post ID, the assets already downloaded for it. It is
+// persisted as plain JSON rather than SQLite so the tool keeps no external
+// dependencies.
+type manifest struct {
+	mu    sync.Mutex
+	path  string
+	Posts map[string][]manifestAsset
+}
+
+func loadManifest(path string) (*manifest, error) {
+	m := &manifest{path: path, Posts: make(map[string][]manifestAsset)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &m.Posts); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// hasAsset reports whether this specific asset (a post can have several,
+// e.g. a photoset) has already been downloaded, rather than just whether
+// the post was seen before -- a post with a partially-failed download must
+// still be retried for the assets it's missing.
+func (m *manifest) hasAsset(postId, url string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, a := range m.Posts[postId] {
+		if a.Url == url {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *manifest) record(postId, url, hash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, a := range m.Posts[postId] {
+		if a.Url == url {
+			m.Posts[postId][i].Hash = hash
+			return
+		}
+	}
+	m.Posts[postId] = append(m.Posts[postId], manifestAsset{Url: url, Hash: hash})
+}
+
+// save writes the manifest to disk, via a temp file + rename so a crawl
+// killed mid-write can't leave behind a corrupt state file.
+func (m *manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.MarshalIndent(m.Posts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// allPostsKnown reports whether every asset produced by every post on the
+// page is already present in the manifest, the signal the -all loop uses to
+// stop paging early during an incremental -resume sync. It mirrors
+// DownloadImages' own per-asset dedupe check rather than just checking post
+// IDs, so a page with a partially-failed download is never mistaken for
+// fully caught up.
+func allPostsKnown(t Tumblr, m *manifest) bool {
+	if len(t.Posts) == 0 {
+		return false
+	}
+	for _, post := range t.Posts {
+		handler, ok := postHandlers[post.Class]
+		if !ok {
+			continue
+		}
+		for _, a := range handler(post) {
+			if !m.hasAsset(post.Id, a.url) {
+				return false
+			}
+		}
+	}
+	return true
+}
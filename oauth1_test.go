@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// TestOAuthSignatureBase pins the signature base string and resulting
+// HMAC-SHA1 signature against a known-good OAuth1 vector (Twitter's
+// published OAuth 1.0a signing example), computed independently with a
+// reference RFC 3986 percent-encoder. This is the test the d0fcb30 fix
+// commit should have shipped with: the first percent-encoding attempt
+// (url.QueryEscape) passed manual review yet was wrong, so this logic
+// needs a pinned vector rather than eyeballing it again next time.
+func TestOAuthSignatureBase(t *testing.T) {
+	reqUrl, err := url.Parse("https://api.twitter.com/1/statuses/update.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := url.Values{}
+	params.Set("oauth_consumer_key", "xvz1evFS4wEEPTGEFPHBog")
+	params.Set("oauth_nonce", "kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg")
+	params.Set("oauth_signature_method", "HMAC-SHA1")
+	params.Set("oauth_timestamp", "1318622958")
+	params.Set("oauth_token", "370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb")
+	params.Set("oauth_version", "1.0")
+	params.Set("status", "Hello Ladies + Gentlemen, a signed OAuth request!")
+	params.Set("include_entities", "true")
+
+	wantBase := "POST&https%3A%2F%2Fapi.twitter.com%2F1%2Fstatuses%2Fupdate.json&" +
+		"include_entities%3Dtrue%26oauth_consumer_key%3Dxvz1evFS4wEEPTGEFPHBog%26" +
+		"oauth_nonce%3DkYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg%26" +
+		"oauth_signature_method%3DHMAC-SHA1%26oauth_timestamp%3D1318622958%26" +
+		"oauth_token%3D370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb%26" +
+		"oauth_version%3D1.0%26status%3DHello%2520Ladies%2520%252B%2520Gentlemen%252C" +
+		"%2520a%2520signed%2520OAuth%2520request%2521"
+
+	if got := oauthSignatureBase("POST", reqUrl, params); got != wantBase {
+		t.Fatalf("oauthSignatureBase() =\n%s\nwant:\n%s", got, wantBase)
+	}
+
+	signingKey := rfc3986Escape("kAcSOqF21Fu85e7zjz7ZN2U4ZRhfV3WpwPAoE3Z7kBw") + "&" +
+		rfc3986Escape("LswwdoUaIvS8ltyTt5jkRh4J50vUPVVHtR2oy")
+	wantSig := "hNndi204AXB2asIef9gn1S39ZUw="
+
+	if got := oauthSignature("POST", reqUrl, params, signingKey); got != wantSig {
+		t.Fatalf("oauthSignature() = %q, want %q", got, wantSig)
+	}
+}
+
+// TestRFC3986Escape guards against regressing back to url.QueryEscape-style
+// form-encoding, which escapes space as "+" instead of "%20" and would
+// silently produce invalid OAuth1 signatures for secrets or tokens
+// containing a space or certain punctuation.
+func TestRFC3986Escape(t *testing.T) {
+	cases := map[string]string{
+		"":                   "",
+		"abc123":             "abc123",
+		"-._~":               "-._~",
+		"Hello Ladies + Gentlemen, a signed OAuth request!": "Hello%20Ladies%20%2B%20Gentlemen%2C%20a%20signed%20OAuth%20request%21",
+		"a/b":                "a%2Fb",
+	}
+	for in, want := range cases {
+		if got := rfc3986Escape(in); got != want {
+			t.Errorf("rfc3986Escape(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+)
+
+// asset is a single downloadable file produced by a post, paired with the
+// filename it should be saved under. Photoset posts produce several assets
+// per post; photo, video, and audio posts produce at most one.
+type asset struct {
+	postId   string
+	url      string
+	filename string
+}
+
+// postHandler derives the downloadable assets for a post of a given type.
+// It returns nil when the post has nothing to download (e.g. a text post,
+// or a video/audio post whose embed couldn't be parsed).
+type postHandler func(Post) []asset
+
+// postHandlers is the post-type registry: DownloadImages dispatches on
+// post.Class through this map instead of a hard-coded type check, so
+// supporting a new Tumblr post type only means adding an entry here.
+var postHandlers = map[string]postHandler{
+	"photo": photoAssets,
+	"video": videoAssets,
+	"audio": audioAssets,
+}
+
+// photoAssets handles both single-photo posts and photosets. Photoset
+// entries are named "{postid}_{index}.{ext}" so they don't collide with
+// each other when saved into the same directory.
+func photoAssets(p Post) []asset {
+	if len(p.Photos) > 0 {
+		assets := make([]asset, 0, len(p.Photos))
+		for i, photo := range p.Photos {
+			if photo.PhotoUrl == "" {
+				continue
+			}
+			assets = append(assets, asset{
+				postId:   p.Id,
+				url:      photo.PhotoUrl,
+				filename: fmt.Sprintf("%s_%d%s", p.Id, i, extOrDefault(photo.PhotoUrl, ".jpg")),
+			})
+		}
+		return assets
+	}
+
+	if p.PhotoUrl == "" {
+		return nil
+	}
+	return []asset{{postId: p.Id, url: p.PhotoUrl, filename: path.Base(p.PhotoUrl)}}
+}
+
+// videoSourceRe matches the <source src="..."> tags in the HTML embed
+// Tumblr's legacy API returns in a video post's "video-player" field.
+// Tumblr lists sources lowest-quality first, so the last match is the
+// highest-quality direct source URL.
+var videoSourceRe = regexp.MustCompile(`<source src="([^"]+)"`)
+
+func videoAssets(p Post) []asset {
+	sourceUrl := highestQualityVideoSource(p.VideoPlayer)
+	if sourceUrl == "" {
+		return nil
+	}
+	return []asset{{postId: p.Id, url: sourceUrl, filename: fmt.Sprintf("%s%s", p.Id, extOrDefault(sourceUrl, ".mp4"))}}
+}
+
+func highestQualityVideoSource(embed string) string {
+	matches := videoSourceRe.FindAllStringSubmatch(embed, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[len(matches)-1][1]
+}
+
+func audioAssets(p Post) []asset {
+	if p.AudioUrl == "" {
+		return nil
+	}
+	return []asset{{postId: p.Id, url: p.AudioUrl, filename: fmt.Sprintf("%s%s", p.Id, extOrDefault(p.AudioUrl, ".mp3"))}}
+}
+
+// extOrDefault returns the file extension of rawurl's path component, or
+// def if the URL has none (query strings and fragments are ignored).
+func extOrDefault(rawurl string, def string) string {
+	if u, err := url.Parse(rawurl); err == nil {
+		if ext := path.Ext(u.Path); ext != "" {
+			return ext
+		}
+	}
+	return def
+}
@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestPhotoAssetsSingle(t *testing.T) {
+	p := Post{Id: "1", PhotoUrl: "https://example.com/img/abc.jpg"}
+
+	assets := photoAssets(p)
+	if len(assets) != 1 {
+		t.Fatalf("got %d assets, want 1", len(assets))
+	}
+	want := asset{postId: "1", url: "https://example.com/img/abc.jpg", filename: "abc.jpg"}
+	if assets[0] != want {
+		t.Errorf("got %+v, want %+v", assets[0], want)
+	}
+}
+
+func TestPhotoAssetsPhotoset(t *testing.T) {
+	p := Post{
+		Id: "2",
+		Photos: []Photo{
+			{PhotoUrl: "https://example.com/a.jpg"},
+			{PhotoUrl: ""}, // missing url, must be skipped rather than downloaded as an empty asset
+			{PhotoUrl: "https://example.com/b.png"},
+		},
+	}
+
+	assets := photoAssets(p)
+	if len(assets) != 2 {
+		t.Fatalf("got %d assets, want 2 (the empty photo url should be skipped): %+v", len(assets), assets)
+	}
+	if assets[0].filename != "2_0.jpg" || assets[0].url != "https://example.com/a.jpg" {
+		t.Errorf("first asset = %+v", assets[0])
+	}
+	if assets[1].filename != "2_2.png" || assets[1].url != "https://example.com/b.png" {
+		t.Errorf("second asset = %+v", assets[1])
+	}
+}
+
+func TestPhotoAssetsNone(t *testing.T) {
+	if assets := photoAssets(Post{Id: "3"}); assets != nil {
+		t.Errorf("got %+v, want nil for a post with no photo", assets)
+	}
+}
+
+func TestVideoAssetsPicksHighestQualitySource(t *testing.T) {
+	p := Post{
+		Id:          "4",
+		VideoPlayer: `<source src="https://example.com/low.mp4"><source src="https://example.com/high.mp4">`,
+	}
+
+	assets := videoAssets(p)
+	if len(assets) != 1 {
+		t.Fatalf("got %d assets, want 1", len(assets))
+	}
+	if assets[0].url != "https://example.com/high.mp4" {
+		t.Errorf("url = %q, want the last (highest-quality) source", assets[0].url)
+	}
+	if assets[0].filename != "4.mp4" {
+		t.Errorf("filename = %q, want 4.mp4", assets[0].filename)
+	}
+}
+
+func TestVideoAssetsUnparsableEmbed(t *testing.T) {
+	if assets := videoAssets(Post{Id: "5", VideoPlayer: "<iframe></iframe>"}); assets != nil {
+		t.Errorf("got %+v, want nil when no <source> tag is found", assets)
+	}
+}
+
+func TestAudioAssets(t *testing.T) {
+	assets := audioAssets(Post{Id: "6", AudioUrl: "https://example.com/track"})
+	if len(assets) != 1 {
+		t.Fatalf("got %d assets, want 1", len(assets))
+	}
+	if assets[0].filename != "6.mp3" {
+		t.Errorf("filename = %q, want the default .mp3 extension for an extensionless url", assets[0].filename)
+	}
+}
+
+func TestExtOrDefault(t *testing.T) {
+	cases := []struct {
+		rawurl, def, want string
+	}{
+		{"https://example.com/a/b.png", ".jpg", ".png"},
+		{"https://example.com/a/b", ".jpg", ".jpg"},
+		{"https://example.com/a/b.png?size=1280", ".jpg", ".png"},
+		{"://not a url", ".jpg", ".jpg"},
+	}
+	for _, c := range cases {
+		if got := extOrDefault(c.rawurl, c.def); got != c.want {
+			t.Errorf("extOrDefault(%q, %q) = %q, want %q", c.rawurl, c.def, got, c.want)
+		}
+	}
+}
@@ -3,7 +3,7 @@
 
    To Build:
 
-       go build -o tumblr-download tumblr-download.go
+       go build -o tumblr-download .
 
    To Run:
 
@@ -18,15 +18,32 @@
        # (helpful for debugging)
        tumblr-download -raw http://jnightscape.tumblr.com
 
+       # download with a bigger/smaller worker pool (default 5)
+       tumblr-download -workers 10 -all http://jnightscape.tumblr.com
+
+       # resume a previous -all crawl, skipping anything already downloaded
+       tumblr-download -all -resume http://jnightscape.tumblr.com
+
+       # emit newline-delimited JSON events on stdout instead of
+       # human-readable text, for embedding as a subprocess
+       tumblr-download -json -all http://jnightscape.tumblr.com
+
+       # use the official v2 API instead of the legacy endpoint
+       # (reads TUMBLR_API_KEY if -api-key isn't given)
+       tumblr-download -api-key XXXX -all http://jnightscape.tumblr.com
+
    Note:
 
        Pictures will download to the current working directory where
-       you're running the command.
+       you're running the command. A local manifest (.tumblr-download.state)
+       is kept there too, recording what's already been downloaded.
 */
 package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -34,22 +51,121 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"path"
 	"strings"
+	"sync"
 	"time"
 )
 
+const userAgent = "tumblr-download/1.0 (+https://github.com/Revario/tumblr_downloader)"
+
+// maxRetries is the number of extra attempts made for a request that fails
+// with a retryable status (429 or 5xx) or a transport-level error.
+const maxRetries = 4
+
 var pageCounter = 0
 
-type Post struct {
-	Id       string `json:"id"`
-	Url      string `json:"url"`
-	Class    string `json:"type"`
-	Date     string `json:"date"`
+// rateLimiter enforces a minimum gap between requests to the same host so a
+// worker pool doesn't hammer a single Tumblr blog or CDN host at once.
+var rateLimiter = newHostRateLimiter(200 * time.Millisecond)
+
+type hostRateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[string]time.Time
+}
+
+func newHostRateLimiter(interval time.Duration) *hostRateLimiter {
+	return &hostRateLimiter{interval: interval, last: make(map[string]time.Time)}
+}
+
+func (r *hostRateLimiter) wait(host string) {
+	r.mu.Lock()
+	now := time.Now()
+	sleep := time.Duration(0)
+	if last, ok := r.last[host]; ok {
+		if elapsed := now.Sub(last); elapsed < r.interval {
+			sleep = r.interval - elapsed
+		}
+	}
+	r.last[host] = now.Add(sleep)
+	r.mu.Unlock()
+
+	if sleep > 0 {
+		time.Sleep(sleep)
+	}
+}
+
+// httpGetWithRetry issues a GET request with a User-Agent header, retrying
+// with exponential backoff when the response is rate-limited (429) or a
+// server error (5xx), or when the request itself fails to go out.
+func httpGetWithRetry(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return httpDoWithRetry(req)
+}
+
+// httpDoWithRetry executes req with the same retry/backoff policy as
+// httpGetWithRetry. Callers that need non-GET methods or extra headers
+// (e.g. V2Client's OAuth1 Authorization header) build their own *http.Request
+// and call this directly.
+func httpDoWithRetry(req *http.Request) (*http.Response, error) {
+	return httpDoWithRetrySigned(req, nil)
+}
+
+// httpDoWithRetrySigned is httpDoWithRetry plus an optional resign hook run
+// immediately before every attempt, including the first. It exists for
+// requests signed with OAuth1: a signature embeds a nonce and timestamp that
+// a spec-compliant server treats as single-use, so simply replaying the
+// same signed request on retry reads as a detected replay and fails even
+// when the original 429/5xx was transient. resign lets the caller
+// regenerate the nonce/timestamp and re-sign req for each attempt.
+func httpDoWithRetrySigned(req *http.Request, resign func(*http.Request)) (*http.Response, error) {
+	backoff := 500 * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if resign != nil {
+			resign(req)
+		}
+		rateLimiter.wait(req.URL.Host)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			lastErr = err
+		} else if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("%s: status %d", req.URL, resp.StatusCode)
+		} else {
+			return resp, nil
+		}
+
+		if attempt < maxRetries {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return nil, fmt.Errorf("giving up after %d attempts: %v", maxRetries+1, lastErr)
+}
+
+type Photo struct {
 	Caption  string `json:"photo-caption"`
 	PhotoUrl string `json:"photo-url-1280"`
 }
 
+type Post struct {
+	Id          string  `json:"id"`
+	Url         string  `json:"url"`
+	Class       string  `json:"type"`
+	Date        string  `json:"date"`
+	Caption     string  `json:"photo-caption"`
+	PhotoUrl    string  `json:"photo-url-1280"`
+	Photos      []Photo `json:"photos"`
+	VideoPlayer string  `json:"video-player"`
+	AudioUrl    string  `json:"audio-url"`
+}
+
 type TumblrLog struct {
 	Title string `json:"title"`
 	Name  string `json:"name"`
@@ -61,16 +177,64 @@ type Tumblr struct {
 	NumberOfPosts int       `json:"posts-total"`
 }
 
+// pageSize is the number of posts requested per page, and the width of the
+// window re-fetched post-by-post when a batched page comes back malformed.
+const pageSize = 20
+
+func pageStart(page int) int {
+	if page == 1 {
+		return 0
+	}
+	return (page - 1) * pageSize
+}
+
+// defaultClient is the backend NewTumblr delegates to. main() points it at
+// a *V2Client when an API key is configured; otherwise it stays the legacy
+// /api/read/json client.
+var defaultClient Client = LegacyClient{}
+
+// NewTumblr is a thin facade over defaultClient so call sites don't need to
+// know whether they're talking to the legacy endpoint or the v2 API.
 func NewTumblr(url string, page int, silent bool) Tumblr {
-	contents := GetJson(url, page, silent)
+	t, err := defaultClient.FetchPage(url, pageStart(page), pageSize, silent)
+	if err != nil {
+		log.Fatal("Trouble fetching posts! -- ", err)
+	}
+	return t
+}
 
+// fetchPostsIndividually works around a known Tumblr server bug where a
+// batched page (?num=N&start=M) returns malformed XML/JSON when exactly one
+// post in the window is corrupt. It re-requests the same window one post at
+// a time (num=1), logs and skips only the individual offending offset, and
+// stitches the rest back together so long -all crawls don't quietly lose a
+// whole page over one bad post.
+func fetchPostsIndividually(url string, start, num int) Tumblr {
 	var t Tumblr
-	json.Unmarshal(contents, &t)
+	haveMeta := false
+
+	for offset := start; offset < start+num; offset++ {
+		contents := fetchJson(url, offset, 1, true)
+
+		var single Tumblr
+		if err := json.Unmarshal(contents, &single); err != nil {
+			log.Printf("tumblr-download: skipping malformed post at offset %d for %s: %v", offset, url, err)
+			continue
+		}
+
+		if !haveMeta {
+			t.Blog = single.Blog
+			t.NumberOfPosts = single.NumberOfPosts
+			haveMeta = true
+		}
+		t.Posts = append(t.Posts, single.Posts...)
+	}
+
 	return t
 }
 
-func GetJson(url string, page int, silent bool) []byte {
-	contents := restRequest(url, page, silent)
+func fetchJson(url string, start, num int, silent bool) []byte {
+	contents := restRequest(url, start, num, silent)
 	contents = filterContent(contents, "var tumblr_api_read = ", "", 1)
 	contents = filterContent(contents, ";", "", -1)
 	return contents
@@ -82,24 +246,18 @@ func filterContent(data []byte, orig string, target string, n int) []byte {
 	return []byte(c)
 }
 
-func restRequest(url string, page int, silent bool) []byte {
-	if page != 1 {
-		p := (page - 1) * 20
-		url = fmt.Sprintf("%s/api/read/json?start=%d", url, p)
-	} else {
-		url = fmt.Sprintf("%s/api/read/json", url)
-	}
+func restRequest(url string, start int, num int, silent bool) []byte {
+	url = fmt.Sprintf("%s/api/read/json?num=%d&start=%d", url, num, start)
 
 	if !silent {
-		fmt.Println("REST Request url: ", url)
+		fmt.Fprintln(humanOut, "REST Request url: ", url)
 	}
 
-	resp, err := http.Get(url)
-	defer resp.Body.Close()
-
+	resp, err := httpGetWithRetry(url)
 	if err != nil {
-		log.Fatal("Trouble making REST GET request!")
+		log.Fatal("Trouble making REST GET request! -- ", err)
 	}
+	defer resp.Body.Close()
 
 	contents, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -121,61 +279,212 @@ func displayRawJson(contents []byte) {
 	os.Exit(0)
 }
 
-func (t Tumblr) DownloadImages(silent bool) {
+// DownloadStats summarizes the outcome of a DownloadImages run so a single
+// bad URL can be reported instead of aborting the whole crawl.
+type DownloadStats struct {
+	Succeeded int
+	Failed    int
+	Errors    []error
+}
 
-	if silent {
-		for _, post := range t.Posts {
-			if post.Class != "photo" {
-				continue
+type downloadResult struct {
+	asset asset
+	hash  string
+	err   error
+}
+
+// DownloadImages fans a page's downloadable posts out across a bounded pool
+// of worker goroutines, collects the per-asset outcome on a results channel,
+// and returns a summary once every job has been processed. Each post's
+// assets are derived through the postHandlers registry, so a photoset post
+// can contribute several jobs while a photo or video post contributes one.
+//
+// Every successful download is recorded into m (when m is non-nil) so a
+// later -resume run can skip it. The skip itself only kicks in when resume
+// is true and force is false: m is still loaded and updated on a plain run,
+// but a stale manifest from an earlier crawl must never silently make a
+// plain run download nothing. The check is per-asset rather than per-post,
+// so a photoset with one failed photo is retried for just that photo
+// instead of being treated as fully downloaded.
+func (t Tumblr) DownloadImages(silent bool, workers int, m *manifest, resume bool, force bool) DownloadStats {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan asset)
+	results := make(chan downloadResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for a := range jobs {
+				hash, err := a.download()
+				results <- downloadResult{asset: a, hash: hash, err: err}
 			}
-			post.downloadImage()
-		}
-	} else {
+		}()
+	}
+
+	go func() {
 		for i, post := range t.Posts {
-			fmt.Println("Post # ", i)
-			fmt.Println(" ---> Caption: ", post.Caption)
-			fmt.Println(" ---> Url    : ", post.PhotoUrl)
-			if post.Class != "photo" {
-				fmt.Println(" ---> SKIPPING (not photo post)")
+			events.emit(event{Type: "post_seen", PostId: post.Id, PostType: post.Class})
+
+			if !silent {
+				fmt.Fprintln(humanOut, "Post # ", i)
+				fmt.Fprintln(humanOut, " ---> Type   : ", post.Class)
+				fmt.Fprintln(humanOut, " ---> Caption: ", post.Caption)
+			}
+
+			handler, ok := postHandlers[post.Class]
+			if !ok {
+				if !silent {
+					fmt.Fprintln(humanOut, " ---> SKIPPING (unsupported post type)")
+				}
 				continue
 			}
-			post.downloadImage()
-			fmt.Println()
+
+			assets := handler(post)
+			if len(assets) == 0 {
+				if !silent {
+					fmt.Fprintln(humanOut, " ---> SKIPPING (no downloadable asset)")
+				}
+				continue
+			}
+
+			if resume && !force && m != nil {
+				pending := assets[:0]
+				for _, a := range assets {
+					if !m.hasAsset(a.postId, a.url) {
+						pending = append(pending, a)
+					}
+				}
+				assets = pending
+				if len(assets) == 0 {
+					if !silent {
+						fmt.Fprintln(humanOut, " ---> SKIPPING (already downloaded, see", manifestPath, ")")
+					}
+					continue
+				}
+			}
+
+			for _, a := range assets {
+				jobs <- a
+			}
+		}
+		close(jobs)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var stats DownloadStats
+	for res := range results {
+		if res.err != nil {
+			stats.Failed++
+			stats.Errors = append(stats.Errors, res.err)
+			if !silent {
+				fmt.Fprintln(humanOut, " ---> FAILED: ", res.err)
+			}
+		} else {
+			stats.Succeeded++
+			if m != nil {
+				m.record(res.asset.postId, res.asset.url, res.hash)
+			}
 		}
 	}
-
+	return stats
 }
 
-func (p Post) downloadImage() {
-	resp, err := http.Get(p.PhotoUrl)
-	defer resp.Body.Close()
+func (a asset) download() (hash string, err error) {
+	events.emit(event{Type: "download_started", PostId: a.postId, Url: a.url, Filename: a.filename})
+	start := time.Now()
+
+	defer func() {
+		if err != nil {
+			events.emit(event{Type: "download_error", PostId: a.postId, Url: a.url, Error: err.Error()})
+		}
+	}()
 
+	resp, err := httpGetWithRetry(a.url)
 	if err != nil {
-		log.Fatal("Trouble making GET photo request!")
+		return "", fmt.Errorf("GET %s: %v", a.url, err)
 	}
+	defer resp.Body.Close()
 
 	contents, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal("Trouble reading response body!")
+		return "", fmt.Errorf("reading response body for %s: %v", a.url, err)
 	}
 
-	filename := path.Base(p.PhotoUrl)
+	filename := a.filename
 	if filename == "" {
-		log.Fatalf("Trouble deriving file name for %s", p.PhotoUrl)
+		return "", fmt.Errorf("could not derive file name for %s", a.url)
 	}
 
-	err = ioutil.WriteFile(filename, contents, 0644)
-	if err != nil {
-		log.Fatal("Trouble creating file! -- ", err)
+	if err = ioutil.WriteFile(filename, contents, 0644); err != nil {
+		return "", fmt.Errorf("creating file for %s: %v", a.url, err)
 	}
+
+	events.emit(event{
+		Type:       "download_finished",
+		PostId:     a.postId,
+		Url:        a.url,
+		Filename:   filename,
+		Bytes:      int64(len(contents)),
+		DurationMs: time.Since(start).Milliseconds(),
+	})
+
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// printSummary reports how many images downloaded successfully and, if any
+// failed, lists each failure so the run's output is actionable even when
+// some images were skipped.
+func printSummary(stats DownloadStats) {
+	fmt.Fprintf(humanOut, "Summary: %d succeeded, %d failed\n", stats.Succeeded, stats.Failed)
+	for _, err := range stats.Errors {
+		fmt.Fprintln(humanOut, " ---> ", err)
+	}
+	events.emit(event{Type: "run_summary", Succeeded: stats.Succeeded, Failed: stats.Failed})
 }
 
 func main() {
 	pagePtr := flag.Int("page", 1, "blog page to download")
 	rawJsonPtr := flag.Bool("raw", false, "dump raw json output for debugging")
 	allPtr := flag.Bool("all", false, "downloads all images")
+	workersPtr := flag.Int("workers", 5, "number of concurrent download workers")
+	resumePtr := flag.Bool("resume", false, "skip posts already recorded in "+manifestPath+" and stop paging once a page is fully known")
+	forcePtr := flag.Bool("force", false, "ignore "+manifestPath+" and redownload everything")
+	jsonPtr := flag.Bool("json", false, "emit newline-delimited JSON events on stdout instead of human-readable output")
+	apiKeyPtr := flag.String("api-key", os.Getenv("TUMBLR_API_KEY"), "Tumblr v2 API key; switches from the legacy endpoint to the official v2 API (also read from TUMBLR_API_KEY)")
+	oauthConsumerKeyPtr := flag.String("oauth-consumer-key", "", "OAuth1 consumer key, for v2 requests against private/NSFW blogs")
+	oauthConsumerSecretPtr := flag.String("oauth-consumer-secret", "", "OAuth1 consumer secret")
+	oauthTokenPtr := flag.String("oauth-token", "", "OAuth1 token")
+	oauthTokenSecretPtr := flag.String("oauth-token-secret", "", "OAuth1 token secret")
 	flag.Parse()
 
+	if *jsonPtr {
+		humanOut = os.Stderr
+		events = newEventEmitter(os.Stdout)
+	}
+
+	if *apiKeyPtr != "" {
+		v2 := &V2Client{APIKey: *apiKeyPtr}
+		if *oauthConsumerKeyPtr != "" && *oauthConsumerSecretPtr != "" && *oauthTokenPtr != "" && *oauthTokenSecretPtr != "" {
+			v2.OAuth = &OAuth1Credentials{
+				ConsumerKey:    *oauthConsumerKeyPtr,
+				ConsumerSecret: *oauthConsumerSecretPtr,
+				Token:          *oauthTokenPtr,
+				TokenSecret:    *oauthTokenSecretPtr,
+			}
+		}
+		defaultClient = v2
+	}
+
 	url := strings.TrimSuffix(flag.Arg(0), "/")
 
 	if url == "" {
@@ -185,11 +494,19 @@ func main() {
 	}
 
 	if *rawJsonPtr == true {
-		contents := GetJson(url, *pagePtr, false)
+		contents, err := defaultClient.FetchRaw(url, pageStart(*pagePtr), pageSize, false)
+		if err != nil {
+			log.Fatal("Trouble fetching raw JSON! -- ", err)
+		}
 		displayRawJson(contents)
 		os.Exit(0)
 	}
 
+	m, err := loadManifest(manifestPath)
+	if err != nil {
+		log.Fatal("Trouble loading manifest! -- ", err)
+	}
+
 	if *allPtr == true {
 		t := NewTumblr(url, *pagePtr, true)
 		pages := t.NumberOfPosts / 20
@@ -197,21 +514,42 @@ func main() {
 			pages++
 		}
 
+		var stats DownloadStats
 		for i := 1; i < pages; i++ {
+			events.emit(event{Type: "page_started", Page: i})
 			t := NewTumblr(url, i, true)
-			t.DownloadImages(true)
+
+			if *resumePtr && !*forcePtr && allPostsKnown(t, m) {
+				fmt.Fprintf(humanOut, "Resuming: page %d is fully known, stopping early\n", i)
+				break
+			}
+
+			pageStats := t.DownloadImages(true, *workersPtr, m, *resumePtr, *forcePtr)
+			stats.Succeeded += pageStats.Succeeded
+			stats.Failed += pageStats.Failed
+			stats.Errors = append(stats.Errors, pageStats.Errors...)
 			pageCounter++
 			time.Sleep(time.Duration(10) * time.Second)
 		}
 
-		fmt.Printf("Done! %d of %d pages downloaded", pageCounter, pages)
+		if err := m.save(); err != nil {
+			fmt.Fprintln(os.Stderr, "Trouble saving manifest! -- ", err)
+		}
+
+		fmt.Fprintf(humanOut, "Done! %d of %d pages downloaded\n", pageCounter, pages)
+		printSummary(stats)
 		os.Exit(0)
 
 	} else {
+		events.emit(event{Type: "page_started", Page: *pagePtr})
 		t := NewTumblr(url, *pagePtr, false)
-		fmt.Println("Blog Title: ", t.Blog.Title)
-		fmt.Println("Number of Posts: ", t.NumberOfPosts)
-		t.DownloadImages(false)
+		fmt.Fprintln(humanOut, "Blog Title: ", t.Blog.Title)
+		fmt.Fprintln(humanOut, "Number of Posts: ", t.NumberOfPosts)
+		stats := t.DownloadImages(false, *workersPtr, m, *resumePtr, *forcePtr)
+		if err := m.save(); err != nil {
+			fmt.Fprintln(os.Stderr, "Trouble saving manifest! -- ", err)
+		}
+		printSummary(stats)
 		os.Exit(0)
 	}
 
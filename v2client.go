@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// V2Client talks to the official api.tumblr.com/v2 blog endpoint, which
+// Tumblr has kept stable while progressively breaking the legacy
+// /api/read/json endpoint LegacyClient uses.
+//
+// Public blogs only need an APIKey. NSFW or private blogs additionally need
+// OAuth set to credentials for a user who can see them.
+type V2Client struct {
+	APIKey string
+	OAuth  *OAuth1Credentials
+
+	mu     sync.Mutex
+	cursor map[string]int64 // "host:start" -> before-timestamp cursor for the next page
+}
+
+func (c *V2Client) cursorFor(host string, start int) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ts, ok := c.cursor[host+":"+strconv.Itoa(start)]
+	return ts, ok
+}
+
+func (c *V2Client) storeCursor(host string, start int, timestamp int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cursor == nil {
+		c.cursor = make(map[string]int64)
+	}
+	c.cursor[host+":"+strconv.Itoa(start)] = timestamp
+}
+
+func blogHost(blogUrl string) string {
+	host := strings.TrimPrefix(blogUrl, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	return strings.TrimSuffix(host, "/")
+}
+
+// FetchPage requests posts [start, start+num) for blogUrl. It prefers the
+// v2 "offset" parameter, but once offset pagination for this blog has been
+// exhausted (tracked via cursor, populated from each response's oldest post
+// timestamp) it switches to the "before" cursor so -all can keep paging
+// past whatever ceiling the v2 API puts on offset.
+func (c *V2Client) FetchPage(blogUrl string, start, num int, silent bool) (Tumblr, error) {
+	host := blogHost(blogUrl)
+
+	body, err := c.fetchRaw(host, start, num, silent)
+	if err != nil {
+		return Tumblr{}, err
+	}
+
+	var v2 v2Envelope
+	if err := json.Unmarshal(body, &v2); err != nil {
+		return Tumblr{}, fmt.Errorf("decoding v2 API response: %v", err)
+	}
+	if v2.Meta.Status != 0 && v2.Meta.Status != 200 {
+		return Tumblr{}, fmt.Errorf("v2 API error: %d %s", v2.Meta.Status, v2.Meta.Msg)
+	}
+
+	if len(v2.Response.Posts) > 0 {
+		oldest := v2.Response.Posts[0].Timestamp
+		for _, p := range v2.Response.Posts {
+			if p.Timestamp < oldest {
+				oldest = p.Timestamp
+			}
+		}
+		c.storeCursor(host, start+num, oldest)
+	}
+
+	return v2.Response.toTumblr(), nil
+}
+
+// FetchRaw returns the unparsed v2 API response body for [start, start+num),
+// for -raw.
+func (c *V2Client) FetchRaw(blogUrl string, start, num int, silent bool) ([]byte, error) {
+	return c.fetchRaw(blogHost(blogUrl), start, num, silent)
+}
+
+func (c *V2Client) fetchRaw(host string, start, num int, silent bool) ([]byte, error) {
+	values := url.Values{}
+	values.Set("api_key", c.APIKey)
+	values.Set("limit", strconv.Itoa(num))
+	if before, ok := c.cursorFor(host, start); ok {
+		values.Set("before", strconv.FormatInt(before, 10))
+	} else {
+		values.Set("offset", strconv.Itoa(start))
+	}
+
+	reqUrl := fmt.Sprintf("https://api.tumblr.com/v2/blog/%s/posts?%s", host, values.Encode())
+
+	req, err := http.NewRequest("GET", reqUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	if !silent {
+		fmt.Fprintln(humanOut, "v2 API request url: ", reqUrl)
+	}
+
+	// OAuth1Credentials.sign embeds a nonce and timestamp that a
+	// spec-compliant server treats as single-use, so a request can't just be
+	// signed once and replayed across retries -- each attempt needs its own
+	// signature. resign is passed through to httpDoWithRetrySigned so it
+	// runs right before every attempt, including the first.
+	var resign func(*http.Request)
+	if c.OAuth != nil {
+		resign = c.OAuth.sign
+	}
+
+	resp, err := httpDoWithRetrySigned(req, resign)
+	if err != nil {
+		return nil, fmt.Errorf("v2 API request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading v2 API response: %v", err)
+	}
+	return body, nil
+}
+
+// v2Envelope mirrors the {"meta": ..., "response": ...} wrapper every v2 API
+// call returns.
+type v2Envelope struct {
+	Meta struct {
+		Status int    `json:"status"`
+		Msg    string `json:"msg"`
+	} `json:"meta"`
+	Response v2BlogResponse `json:"response"`
+}
+
+type v2BlogResponse struct {
+	Blog struct {
+		Title string `json:"title"`
+		Name  string `json:"name"`
+	} `json:"blog"`
+	Posts      []v2Post `json:"posts"`
+	TotalPosts int      `json:"total_posts"`
+}
+
+func (b v2BlogResponse) toTumblr() Tumblr {
+	t := Tumblr{
+		Blog:          TumblrLog{Title: b.Blog.Title, Name: b.Blog.Name},
+		NumberOfPosts: b.TotalPosts,
+	}
+	for _, vp := range b.Posts {
+		t.Posts = append(t.Posts, vp.toPost())
+	}
+	return t
+}
+
+// v2Post covers the fields of the v2 API's photo, video, and audio post
+// types; toPost translates it into the same Post shape the legacy client
+// and the postHandlers registry already understand.
+type v2Post struct {
+	Id        json.Number `json:"id"`
+	PostUrl   string      `json:"post_url"`
+	Type      string      `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+	Caption   string      `json:"caption"`
+	Photos    []struct {
+		Caption      string `json:"caption"`
+		OriginalSize struct {
+			Url string `json:"url"`
+		} `json:"original_size"`
+	} `json:"photos"`
+	VideoUrl string `json:"video_url"`
+	Player   []struct {
+		EmbedCode string `json:"embed_code"`
+	} `json:"player"`
+	AudioUrl       string `json:"audio_url"`
+	AudioSourceUrl string `json:"audio_source_url"`
+}
+
+func (vp v2Post) toPost() Post {
+	p := Post{
+		Id:      vp.Id.String(),
+		Url:     vp.PostUrl,
+		Class:   vp.Type,
+		Caption: vp.Caption,
+	}
+
+	switch vp.Type {
+	case "photo":
+		for _, photo := range vp.Photos {
+			p.Photos = append(p.Photos, Photo{Caption: photo.Caption, PhotoUrl: photo.OriginalSize.Url})
+		}
+		if len(p.Photos) == 1 {
+			p.PhotoUrl = p.Photos[0].PhotoUrl
+			p.Photos = nil
+		}
+	case "video":
+		if vp.VideoUrl != "" {
+			p.VideoPlayer = fmt.Sprintf(`<source src="%s">`, vp.VideoUrl)
+		} else if len(vp.Player) > 0 {
+			p.VideoPlayer = vp.Player[len(vp.Player)-1].EmbedCode
+		}
+	case "audio":
+		if vp.AudioUrl != "" {
+			p.AudioUrl = vp.AudioUrl
+		} else {
+			p.AudioUrl = vp.AudioSourceUrl
+		}
+	}
+
+	return p
+}